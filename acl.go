@@ -0,0 +1,74 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// aclAllows reports whether the caller described by ctx may run the given
+// command (and, for multi-word commands like "github reload", the given
+// subcommand). Commands with no matching Acl entry are open to everyone,
+// so existing public commands need no configuration changes.
+func aclAllows(ctx *CommandContext, name string, args []string) bool {
+	cfg := currentConfig()
+	if len(args) > 0 {
+		if acl, ok := cfg.Acl[name+" "+args[0]]; ok {
+			return acl.allows(ctx)
+		}
+	}
+	acl, ok := cfg.Acl[name]
+	if !ok {
+		return true
+	}
+	return acl.allows(ctx)
+}
+
+// allows checks ctx's nick!user@host against the ACL's allow list, and its
+// channel against the ACL's channel list when one is configured.
+func (a *aclEntry) allows(ctx *CommandContext) bool {
+	if len(a.Channel) > 0 && !stringInSlice(ctx.Channel, a.Channel) {
+		return false
+	}
+	if len(a.Allow) == 0 {
+		return true
+	}
+	mask := ctx.Hostmask()
+	for _, pattern := range a.Allow {
+		if hostmaskMatch(pattern, mask) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostmaskMatch matches an IRC hostmask pattern (using '*' and '?' as
+// wildcards, as in "*!*@staff.example.com") against a nick!user@host mask.
+func hostmaskMatch(pattern, mask string) bool {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return false
+	}
+	return re.MatchString(mask)
+}
+
+func stringInSlice(needle string, haystack []string) bool {
+	for _, s := range haystack {
+		if strings.EqualFold(s, needle) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestHostmaskMatch(t *testing.T) {
+	cases := []struct {
+		pattern, mask string
+		want          bool
+	}{
+		{"*!*@staff.example.com", "alice!alice@staff.example.com", true},
+		{"*!*@staff.example.com", "alice!alice@elsewhere.example.com", false},
+		{"bob!*@*", "bob!bob@anywhere.example.com", true},
+		{"bob!*@*", "bobby!bob@anywhere.example.com", false},
+		{"a?c!*@*", "abc!x@example.com", true},
+		{"a?c!*@*", "abbc!x@example.com", false},
+		{"nick!user@host.example.com", "nick!user@host.example.com", true},
+		{"nick!user@host.example.com", "NICK!user@host.example.com", false},
+	}
+	for _, c := range cases {
+		if got := hostmaskMatch(c.pattern, c.mask); got != c.want {
+			t.Errorf("hostmaskMatch(%q, %q) = %v, want %v", c.pattern, c.mask, got, c.want)
+		}
+	}
+}
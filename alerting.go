@@ -0,0 +1,260 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AlertmanagerAlert is a single alert as sent by Alertmanager's webhook
+// receiver (v4 payload).
+type AlertmanagerAlert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+}
+
+// AlertmanagerWebhook mirrors the top-level object Alertmanager posts to
+// configured webhook receivers.
+type AlertmanagerWebhook struct {
+	Version           string              `json:"version"`
+	GroupKey          string              `json:"groupKey"`
+	Status            string              `json:"status"`
+	Receiver          string              `json:"receiver"`
+	GroupLabels       map[string]string   `json:"groupLabels"`
+	CommonLabels      map[string]string   `json:"commonLabels"`
+	CommonAnnotations map[string]string   `json:"commonAnnotations"`
+	ExternalURL       string              `json:"externalURL"`
+	Alerts            []AlertmanagerAlert `json:"alerts"`
+}
+
+// alertBatcher coalesces alerts destined for a single channel so that a
+// burst doesn't flood IRC: every alert received within CoalesceWindow of
+// the first one in a batch is buffered, then flushed together. A batch of
+// at most CoalesceThreshold alerts is sent one line per alert; a larger one
+// is collapsed into a single "N firing" summary line followed by the first
+// three alerts.
+type alertBatcher struct {
+	sync.Mutex
+	irc     IRCClient
+	channel string
+	pending []AlertmanagerAlert
+	timer   *time.Timer
+}
+
+var (
+	batchersMu sync.Mutex
+	batchers   = map[string]*alertBatcher{}
+)
+
+// applyAlertingDefaults fills in the [Alerting] coalescing defaults when
+// unset. Called from setConfig whenever cfg is (re)loaded, rather than from
+// watchAlerting, so every reader of cfg.Alerting sees the same defaulted
+// values instead of racing a one-time mutation in a watcher goroutine.
+func applyAlertingDefaults(c *Config) {
+	if c.Alerting.CoalesceWindow == 0 {
+		c.Alerting.CoalesceWindow = 10 * time.Second
+	}
+	if c.Alerting.CoalesceThreshold == 0 {
+		c.Alerting.CoalesceThreshold = 5
+	}
+}
+
+// watchAlerting starts the Alertmanager webhook listener if one is
+// configured. It joins the alerting channel plus every channel named by a
+// [Route "..."] block up front, so alerts never land in a channel the bot
+// hasn't joined. It runs until the HTTP listener fails.
+func watchAlerting(irc IRCClient) {
+	cfg := currentConfig()
+	if cfg.Alerting.Listen == "" {
+		return
+	}
+
+	if cfg.Alerting.Channel != "" {
+		irc.Join(cfg.Alerting.Channel)
+	}
+	for _, route := range cfg.Route {
+		if route.Channel != "" {
+			irc.Join(route.Channel)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/webhook", func(w http.ResponseWriter, r *http.Request) {
+		handleAlertWebhook(irc, w, r)
+	})
+
+	var err error
+	if cfg.Alerting.TLS {
+		err = http.ListenAndServeTLS(cfg.Alerting.Listen, cfg.Alerting.CertFile, cfg.Alerting.KeyFile, mux)
+	} else {
+		err = http.ListenAndServe(cfg.Alerting.Listen, mux)
+	}
+	if err != nil {
+		log.Printf("alerting: listener on %s exited: %v", cfg.Alerting.Listen, err)
+	}
+}
+
+func handleAlertWebhook(irc IRCClient, w http.ResponseWriter, r *http.Request) {
+	cfg := currentConfig()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "could not read body", http.StatusBadRequest)
+		return
+	}
+
+	if cfg.Alerting.HMACSecret != "" {
+		if !verifyAlertSignature(cfg.Alerting.HMACSecret, body, r.Header.Get("X-Signature")) {
+			http.Error(w, "bad signature", http.StatusForbidden)
+			return
+		}
+	}
+
+	var payload AlertmanagerWebhook
+	if err := json.Unmarshal(body, &payload); err != nil {
+		http.Error(w, "bad payload", http.StatusBadRequest)
+		return
+	}
+
+	for _, alert := range payload.Alerts {
+		channel := routeForAlert(payload.Receiver, alert)
+		if channel == "" {
+			continue
+		}
+		queueAlert(irc, channel, alert)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// verifyAlertSignature checks an "sha256=<hex>" HMAC signature, the same
+// convention used by GitHub webhooks.
+func verifyAlertSignature(secret string, body []byte, header string) bool {
+	if header == "" {
+		return false
+	}
+	header = strings.TrimPrefix(header, "sha256=")
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(header))
+}
+
+// routeForAlert picks the destination channel for an alert, checking
+// (in order) a route keyed on the Alertmanager receiver name, a route
+// keyed on the "alertname" label, and a route keyed on any other
+// "label=value" pair, before falling back to the alerting block's
+// default channel. Route keys are configured as [Route "..."] blocks.
+func routeForAlert(receiver string, alert AlertmanagerAlert) string {
+	cfg := currentConfig()
+	if route, ok := cfg.Route["receiver:"+receiver]; ok && route.Channel != "" {
+		return route.Channel
+	}
+	if route, ok := cfg.Route[alert.Labels["alertname"]]; ok && route.Channel != "" {
+		return route.Channel
+	}
+	for label, value := range alert.Labels {
+		if label == "alertname" {
+			continue
+		}
+		if route, ok := cfg.Route[label+"="+value]; ok && route.Channel != "" {
+			return route.Channel
+		}
+	}
+	return cfg.Alerting.Channel
+}
+
+func queueAlert(irc IRCClient, channel string, alert AlertmanagerAlert) {
+	cfg := currentConfig()
+	batchersMu.Lock()
+	b, ok := batchers[channel]
+	if !ok {
+		b = &alertBatcher{irc: irc, channel: channel}
+		batchers[channel] = b
+	}
+	batchersMu.Unlock()
+
+	b.Lock()
+	defer b.Unlock()
+	b.pending = append(b.pending, alert)
+	if b.timer == nil {
+		b.timer = time.AfterFunc(cfg.Alerting.CoalesceWindow, b.flush)
+	}
+}
+
+func (b *alertBatcher) flush() {
+	cfg := currentConfig()
+	b.Lock()
+	alerts := b.pending
+	b.pending = nil
+	b.timer = nil
+	b.Unlock()
+
+	if len(alerts) == 0 {
+		return
+	}
+
+	if len(alerts) <= cfg.Alerting.CoalesceThreshold {
+		for _, a := range alerts {
+			b.irc.Privmsg(b.channel, formatAlertLine(a))
+		}
+		return
+	}
+
+	firing := 0
+	for _, a := range alerts {
+		if strings.EqualFold(a.Status, "firing") {
+			firing++
+		}
+	}
+	b.irc.Privmsgf(b.channel, "\x0304%d firing\x03 (%d total) in the last %s", firing, len(alerts), cfg.Alerting.CoalesceWindow)
+
+	top := alerts
+	if len(top) > 3 {
+		top = top[:3]
+	}
+	for _, a := range top {
+		b.irc.Privmsg(b.channel, formatAlertLine(a))
+	}
+}
+
+// formatAlertLine renders a single alert as a one-line IRC message, using
+// mIRC colour codes to distinguish firing (red) from resolved (green).
+func formatAlertLine(a AlertmanagerAlert) string {
+	colour := "\x0304" // red
+	if strings.EqualFold(a.Status, "resolved") {
+		colour = "\x0303" // green
+	}
+	name := a.Labels["alertname"]
+	summary := a.Annotations["summary"]
+	if summary == "" {
+		summary = a.Annotations["description"]
+	}
+	labels := make([]string, 0, len(a.Labels))
+	for k, v := range a.Labels {
+		if k == "alertname" {
+			continue
+		}
+		labels = append(labels, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(labels)
+	line := fmt.Sprintf("%s[%s]\x03 %s", colour, strings.ToUpper(a.Status), name)
+	if summary != "" {
+		line += ": " + summary
+	}
+	if len(labels) > 0 {
+		line += " (" + strings.Join(labels, ", ") + ")"
+	}
+	return line
+}
@@ -0,0 +1,139 @@
+package main
+
+import "strings"
+
+// flyCommand is the bot's "fly" easter egg.
+type flyCommand struct{}
+
+func (flyCommand) Name() string { return "fly" }
+func (flyCommand) Help() string { return "fly: makes the bot fly away" }
+func (flyCommand) Run(ctx *CommandContext, args []string) (string, error) {
+	return "PPPPPPFFFFFfffffffffiiiiiiiiiuuuuuuuuuuuuuuuu.....................", nil
+}
+
+// flipCommand flips the given text, table and all.
+type flipCommand struct{}
+
+func (flipCommand) Name() string { return "flip" }
+func (flipCommand) Help() string { return "flip <text>: (ノಠ益ಠ)ノ彡 flips a table at <text>" }
+func (flipCommand) Run(ctx *CommandContext, args []string) (string, error) {
+	return "(ﾉಥ益ಥ）ﾉ ┻━┻ " + strings.Join(args, " "), nil
+}
+
+// githubCommand exposes the github subsystem's repo list, plus a "reload"
+// subcommand that re-reads the config file. Like any command, reload is
+// open to everyone unless gated with an [Acl "github reload"] (or
+// [Acl "github"]) block in config.
+type githubCommand struct{}
+
+func (githubCommand) Name() string { return "github" }
+func (githubCommand) Help() string { return githubHelp }
+func (githubCommand) Run(ctx *CommandContext, args []string) (string, error) {
+	if len(args) > 0 && args[0] == "repos" {
+		return githubPrintReposList(), nil
+	}
+	if len(args) > 0 && args[0] == "reload" {
+		if err := reloadConfig(); err != nil {
+			return "", err
+		}
+		return "configuration reloaded", nil
+	}
+	return "try 'help github'", nil
+}
+
+// helpCommand answers "help" and "help <command>" from the registry's own
+// metadata, so new commands never need to touch this file.
+type helpCommand struct{}
+
+func (helpCommand) Name() string { return "help" }
+func (helpCommand) Help() string { return "help [command]: lists commands, or shows help for one" }
+func (helpCommand) Run(ctx *CommandContext, args []string) (string, error) {
+	if len(args) > 0 {
+		return defaultRegistry.HelpFor(args[0]), nil
+	}
+	return defaultRegistry.HelpSummary(), nil
+}
+
+// ipCommand geolocates an IP address via the maxmind database.
+type ipCommand struct{}
+
+func (ipCommand) Name() string { return "ip" }
+func (ipCommand) Help() string { return geolocationHelp }
+func (ipCommand) Run(ctx *CommandContext, args []string) (string, error) {
+	if len(args) > 0 {
+		return geolocate(args[0]), nil
+	}
+	return "try 'help ip'", nil
+}
+
+// timeCommand reports the time in a given location.
+type timeCommand struct{}
+
+func (timeCommand) Name() string { return "time" }
+func (timeCommand) Help() string { return timeHelp }
+func (timeCommand) Run(ctx *CommandContext, args []string) (string, error) {
+	if len(args) > 0 {
+		return getTimeIn(args[0]), nil
+	}
+	return getTimeIn(""), nil
+}
+
+// stardateCommand reports the current stardate.
+type stardateCommand struct{}
+
+func (stardateCommand) Name() string { return "stardate" }
+func (stardateCommand) Help() string { return "stardate: prints the current stardate" }
+func (stardateCommand) Run(ctx *CommandContext, args []string) (string, error) {
+	return stardateCalc(), nil
+}
+
+// weatherCommand reports a forecast for a given location.
+type weatherCommand struct{}
+
+func (weatherCommand) Name() string { return "weather" }
+func (weatherCommand) Help() string { return weatherHelp }
+func (weatherCommand) Run(ctx *CommandContext, args []string) (string, error) {
+	if len(args) == 0 {
+		return weatherHelp, nil
+	}
+	return getYahooForecast(strings.Join(args, " ")), nil
+}
+
+// untappdCommand exposes the untappd subsystem's tracked user list.
+type untappdCommand struct{}
+
+func (untappdCommand) Name() string { return "untappd" }
+func (untappdCommand) Help() string { return untappdHelp }
+func (untappdCommand) Run(ctx *CommandContext, args []string) (string, error) {
+	if len(args) > 0 && args[0] == "users" {
+		return untappdPrintUsers(), nil
+	}
+	return "try 'help untappd'", nil
+}
+
+// joinCommand makes the bot join another channel. It's operator-only by
+// convention: gate it with an [Acl "join"] block in config.
+type joinCommand struct{}
+
+func (joinCommand) Name() string { return "join" }
+func (joinCommand) Help() string { return "join <#channel>: makes the bot join another channel" }
+func (joinCommand) Run(ctx *CommandContext, args []string) (string, error) {
+	if len(args) == 0 {
+		return "try 'help join'", nil
+	}
+	ctx.Irc.Join(args[0])
+	return "joining " + args[0], nil
+}
+
+func init() {
+	RegisterCommand(flyCommand{})
+	RegisterCommand(flipCommand{})
+	RegisterCommand(githubCommand{})
+	RegisterCommand(helpCommand{})
+	RegisterCommand(ipCommand{})
+	RegisterCommand(timeCommand{})
+	RegisterCommand(stardateCommand{})
+	RegisterCommand(weatherCommand{})
+	RegisterCommand(untappdCommand{})
+	RegisterCommand(joinCommand{})
+}
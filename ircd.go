@@ -0,0 +1,549 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Numeric replies implemented by the embedded server, per RFC 2812.
+const (
+	RPL_WELCOME          = 1
+	RPL_YOURHOST         = 2
+	RPL_CREATED          = 3
+	RPL_MYINFO           = 4
+	RPL_WHOREPLY         = 352
+	RPL_ENDOFWHO         = 315
+	RPL_NAMREPLY         = 353
+	RPL_ENDOFNAMES       = 366
+	ERR_NOSUCHNICK       = 401
+	ERR_NEEDMOREPARAMS   = 461
+	ERR_ALREADYREGISTRED = 462
+)
+
+const (
+	pingInterval = 120 * time.Second
+	pongDeadline = 60 * time.Second
+
+	// maxReplyChunk bounds a single PRIVMSG reply to the bot, same as the
+	// supervisor's PRIVMSG handler does, so a long command response can't
+	// run past the 512-byte IRC line limit.
+	maxReplyChunk = 300
+)
+
+// Server is a minimal RFC 2812 IRC daemon, for running r2d2 standalone in
+// air-gapped or CI environments instead of connecting out to a real
+// network.
+type Server struct {
+	Addr string
+	Name string
+
+	clientsMu sync.RWMutex
+	clients   map[string]*Client
+
+	channelsMu sync.RWMutex
+	channels   map[string]*Channel
+}
+
+// Channel is a named set of clients.
+type Channel struct {
+	Name string
+
+	membersMu sync.RWMutex
+	members   map[string]*Client
+}
+
+// Client is one connected peer: a read goroutine parsing incoming lines
+// and a write goroutine draining a buffered queue, so one slow client
+// can't stall broadcasts to the others.
+type Client struct {
+	server *Server
+	conn   net.Conn
+	out    chan string
+
+	nick, user, realname string
+	registered           bool
+
+	pong   chan struct{}
+	done   chan struct{}
+	closed bool
+	mu     sync.Mutex
+}
+
+// NewServer returns a server ready to ListenAndServe.
+func NewServer(addr, name string) *Server {
+	return &Server{
+		Addr:     addr,
+		Name:     name,
+		clients:  make(map[string]*Client),
+		channels: make(map[string]*Channel),
+	}
+}
+
+// ListenAndServe accepts connections until the listener fails.
+func (s *Server) ListenAndServe() error {
+	ln, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serve(conn)
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	c := &Client{
+		server: s,
+		conn:   conn,
+		out:    make(chan string, 100),
+		pong:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	go c.writeLoop()
+	defer c.quit("connection closed")
+
+	go c.pingLoop()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r\n")
+		if line == "" {
+			continue
+		}
+		c.handleLine(line)
+	}
+}
+
+func (c *Client) writeLoop() {
+	for line := range c.out {
+		fmt.Fprintf(c.conn, "%s\r\n", line)
+	}
+	c.conn.Close()
+}
+
+// send queues a raw line for delivery, dropping it rather than blocking
+// if the client's write queue is full. Holding mu for the whole send
+// keeps it safe to race against quit() closing the channel.
+func (c *Client) send(line string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	select {
+	case c.out <- line:
+	default:
+		log.Printf("ircd: dropping message to %s, write queue full", c.nick)
+	}
+}
+
+func (c *Client) sendf(format string, a ...interface{}) {
+	c.send(fmt.Sprintf(format, a...))
+}
+
+func (c *Client) numeric(code int, rest string) {
+	nick := c.nick
+	if nick == "" {
+		nick = "*"
+	}
+	c.sendf(":%s %03d %s %s", c.server.Name, code, nick, rest)
+}
+
+// pingLoop sends a PING every pingInterval and requires a PONG back within
+// pongDeadline of that PING, not of the interval as a whole: each round
+// waits on c.pong (fed by handleLine's PONG case) with its own deadline
+// timer, so a client only ever gets pongDeadline to respond, not
+// pingInterval+pongDeadline.
+func (c *Client) pingLoop() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.sendf("PING :%s", c.server.Name)
+			select {
+			case <-c.pong:
+			case <-time.After(pongDeadline):
+				c.quit("ping timeout")
+				return
+			case <-c.done:
+				return
+			}
+		}
+	}
+}
+
+// parseIRCLine splits a client line into its command and params, honoring
+// a single trailing ":"-prefixed parameter.
+func parseIRCLine(line string) (command string, params []string) {
+	var trailing string
+	hasTrailing := false
+	if idx := strings.Index(line, " :"); idx >= 0 {
+		trailing = line[idx+2:]
+		hasTrailing = true
+		line = line[:idx]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	command = strings.ToUpper(fields[0])
+	params = fields[1:]
+	if hasTrailing {
+		params = append(params, trailing)
+	}
+	return command, params
+}
+
+func (c *Client) handleLine(line string) {
+	command, params := parseIRCLine(line)
+	if command == "" {
+		return
+	}
+
+	if !c.registered {
+		switch command {
+		case "NICK", "USER", "PING", "PONG", "QUIT":
+			// fall through to normal handling below
+		default:
+			c.numeric(ERR_NEEDMOREPARAMS, command+" :Registration required")
+			return
+		}
+	}
+
+	switch command {
+	case "NICK":
+		c.handleNick(params)
+	case "USER":
+		c.handleUser(params)
+	case "PING":
+		c.sendf("PONG :%s", c.server.Name)
+	case "PONG":
+		select {
+		case c.pong <- struct{}{}:
+		default:
+		}
+	case "JOIN":
+		c.handleJoin(params)
+	case "PART":
+		c.handlePart(params)
+	case "PRIVMSG", "NOTICE":
+		c.handlePrivmsg(command, params)
+	case "QUIT":
+		msg := "leaving"
+		if len(params) > 0 {
+			msg = params[len(params)-1]
+		}
+		c.quit(msg)
+	case "MODE":
+		// no-op: mode changes are accepted but have no effect
+	case "WHO":
+		c.handleWho(params)
+	case "NAMES":
+		c.handleNames(params)
+	default:
+		c.numeric(ERR_NEEDMOREPARAMS, command+" :Unknown command")
+	}
+}
+
+func (c *Client) handleNick(params []string) {
+	if len(params) < 1 {
+		c.numeric(ERR_NEEDMOREPARAMS, "NICK :Not enough parameters")
+		return
+	}
+	if c.registered {
+		c.numeric(ERR_ALREADYREGISTRED, ":You may not reregister")
+		return
+	}
+	c.nick = params[0]
+	c.maybeRegister()
+}
+
+func (c *Client) handleUser(params []string) {
+	if len(params) < 4 {
+		c.numeric(ERR_NEEDMOREPARAMS, "USER :Not enough parameters")
+		return
+	}
+	if c.registered {
+		c.numeric(ERR_ALREADYREGISTRED, ":You may not reregister")
+		return
+	}
+	c.user = params[0]
+	c.realname = params[len(params)-1]
+	c.maybeRegister()
+}
+
+// maybeRegister completes registration once both NICK and USER have been
+// seen, per the RFC's handshake.
+func (c *Client) maybeRegister() {
+	if c.registered || c.nick == "" || c.user == "" {
+		return
+	}
+	c.registered = true
+
+	c.server.clientsMu.Lock()
+	c.server.clients[c.nick] = c
+	c.server.clientsMu.Unlock()
+
+	c.numeric(RPL_WELCOME, fmt.Sprintf(":Welcome to %s, %s", c.server.Name, c.nick))
+	c.numeric(RPL_YOURHOST, fmt.Sprintf(":Your host is %s, running r2d2-ircd", c.server.Name))
+	c.numeric(RPL_CREATED, ":This server was started just now")
+	c.numeric(RPL_MYINFO, fmt.Sprintf("%s r2d2-ircd - -", c.server.Name))
+}
+
+func (c *Client) handleJoin(params []string) {
+	if len(params) < 1 {
+		c.numeric(ERR_NEEDMOREPARAMS, "JOIN :Not enough parameters")
+		return
+	}
+	for _, name := range strings.Split(params[0], ",") {
+		ch := c.server.channel(name, true)
+		ch.membersMu.Lock()
+		ch.members[c.nick] = c
+		ch.membersMu.Unlock()
+
+		ch.broadcast(fmt.Sprintf(":%s JOIN :%s", c.prefix(), name), nil)
+		c.handleNames([]string{name})
+	}
+}
+
+func (c *Client) handlePart(params []string) {
+	if len(params) < 1 {
+		c.numeric(ERR_NEEDMOREPARAMS, "PART :Not enough parameters")
+		return
+	}
+	for _, name := range strings.Split(params[0], ",") {
+		ch := c.server.channel(name, false)
+		if ch == nil {
+			continue
+		}
+		ch.broadcast(fmt.Sprintf(":%s PART :%s", c.prefix(), name), nil)
+		ch.membersMu.Lock()
+		delete(ch.members, c.nick)
+		ch.membersMu.Unlock()
+	}
+}
+
+func (c *Client) handlePrivmsg(command string, params []string) {
+	cfg := currentConfig()
+	if len(params) < 2 {
+		c.numeric(ERR_NEEDMOREPARAMS, command+" :Not enough parameters")
+		return
+	}
+	target, message := params[0], params[len(params)-1]
+
+	if strings.HasPrefix(target, "#") {
+		ch := c.server.channel(target, false)
+		if ch == nil {
+			c.numeric(ERR_NOSUCHNICK, target+" :No such channel")
+			return
+		}
+		ch.broadcast(fmt.Sprintf(":%s %s %s :%s", c.prefix(), command, target, message), c)
+		return
+	}
+
+	if cfg.Ircd.BotNick != "" && target == cfg.Ircd.BotNick && command == "PRIVMSG" {
+		ctx := &CommandContext{Irc: &ircdBot{c.server}, Nick: c.nick, User: c.user, Host: c.server.Name, Channel: c.nick}
+		resp := defaultRegistry.Dispatch(ctx, message)
+		for i := 0; i < len(resp); i += maxReplyChunk {
+			end := i + maxReplyChunk
+			if end > len(resp) {
+				end = len(resp)
+			}
+			c.server.privmsgNick(cfg.Ircd.BotNick, c.nick, resp[i:end])
+		}
+		return
+	}
+
+	dest, ok := c.server.lookupClient(target)
+	if !ok {
+		c.numeric(ERR_NOSUCHNICK, target+" :No such nick")
+		return
+	}
+	dest.sendf(":%s %s %s :%s", c.prefix(), command, target, message)
+}
+
+func (c *Client) handleWho(params []string) {
+	if len(params) < 1 {
+		c.numeric(ERR_NEEDMOREPARAMS, "WHO :Not enough parameters")
+		return
+	}
+	mask := params[0]
+
+	if strings.HasPrefix(mask, "#") {
+		if ch := c.server.channel(mask, false); ch != nil {
+			ch.membersMu.RLock()
+			members := make([]*Client, 0, len(ch.members))
+			for _, member := range ch.members {
+				members = append(members, member)
+			}
+			ch.membersMu.RUnlock()
+			for _, member := range members {
+				c.numeric(RPL_WHOREPLY, whoReplyLine(mask, member))
+			}
+		}
+	} else if member, ok := c.server.lookupClient(mask); ok {
+		c.numeric(RPL_WHOREPLY, whoReplyLine("*", member))
+	}
+
+	c.numeric(RPL_ENDOFWHO, mask+" :End of /WHO list")
+}
+
+// whoReplyLine formats one RPL_WHOREPLY line for member, as seen in channel.
+func whoReplyLine(channel string, member *Client) string {
+	return fmt.Sprintf("%s %s %s %s %s H :0 %s", channel, member.user, member.server.Name, member.server.Name, member.nick, member.realname)
+}
+
+func (c *Client) handleNames(params []string) {
+	if len(params) < 1 {
+		return
+	}
+	ch := c.server.channel(params[0], false)
+	if ch == nil {
+		c.numeric(RPL_ENDOFNAMES, params[0]+" :End of /NAMES list")
+		return
+	}
+	ch.membersMu.RLock()
+	names := make([]string, 0, len(ch.members))
+	for nick := range ch.members {
+		names = append(names, nick)
+	}
+	ch.membersMu.RUnlock()
+	c.numeric(RPL_NAMREPLY, fmt.Sprintf("= %s :%s", params[0], strings.Join(names, " ")))
+	c.numeric(RPL_ENDOFNAMES, params[0]+" :End of /NAMES list")
+}
+
+func (c *Client) prefix() string {
+	return c.nick + "!" + c.user + "@" + c.server.Name
+}
+
+// quit removes c from every channel and the global client table, telling
+// other members why it left.
+func (c *Client) quit(reason string) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	c.mu.Unlock()
+	close(c.done)
+
+	c.server.clientsMu.Lock()
+	if existing, ok := c.server.clients[c.nick]; ok && existing == c {
+		delete(c.server.clients, c.nick)
+	}
+	c.server.clientsMu.Unlock()
+
+	c.server.channelsMu.RLock()
+	channels := make([]*Channel, 0, len(c.server.channels))
+	for _, ch := range c.server.channels {
+		channels = append(channels, ch)
+	}
+	c.server.channelsMu.RUnlock()
+
+	for _, ch := range channels {
+		ch.membersMu.Lock()
+		_, member := ch.members[c.nick]
+		delete(ch.members, c.nick)
+		ch.membersMu.Unlock()
+		if member {
+			ch.broadcast(fmt.Sprintf(":%s QUIT :%s", c.prefix(), reason), nil)
+		}
+	}
+
+	close(c.out)
+}
+
+func (s *Server) channel(name string, create bool) *Channel {
+	s.channelsMu.RLock()
+	ch, ok := s.channels[name]
+	s.channelsMu.RUnlock()
+	if ok || !create {
+		return ch
+	}
+
+	s.channelsMu.Lock()
+	defer s.channelsMu.Unlock()
+	if ch, ok = s.channels[name]; ok {
+		return ch
+	}
+	ch = &Channel{Name: name, members: make(map[string]*Client)}
+	s.channels[name] = ch
+	return ch
+}
+
+func (s *Server) lookupClient(nick string) (*Client, bool) {
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+	c, ok := s.clients[nick]
+	return c, ok
+}
+
+// privmsgNick sends a PRIVMSG to a single connected client from "from",
+// used by the bot to reply on the embedded server.
+func (s *Server) privmsgNick(from, to, message string) {
+	if c, ok := s.lookupClient(to); ok {
+		c.sendf(":%s!%s@%s PRIVMSG %s :%s", from, from, s.Name, to, message)
+	}
+}
+
+// broadcast sends line to every channel member except skip (if non-nil).
+func (ch *Channel) broadcast(line string, skip *Client) {
+	ch.membersMu.RLock()
+	defer ch.membersMu.RUnlock()
+	for nick, member := range ch.members {
+		if skip != nil && nick == skip.nick {
+			continue
+		}
+		member.send(line)
+	}
+}
+
+// ircdBot adapts a Server to IRCClient so the shared command registry can
+// reply through it exactly as it would through a real IRC connection.
+type ircdBot struct {
+	server *Server
+}
+
+func (b *ircdBot) Privmsg(target, message string) {
+	cfg := currentConfig()
+	if strings.HasPrefix(target, "#") {
+		if ch := b.server.channel(target, false); ch != nil {
+			ch.broadcast(fmt.Sprintf(":%s!%s@%s PRIVMSG %s :%s", cfg.Ircd.BotNick, cfg.Ircd.BotNick, b.server.Name, target, message), nil)
+		}
+		return
+	}
+	b.server.privmsgNick(cfg.Ircd.BotNick, target, message)
+}
+
+func (b *ircdBot) Privmsgf(target, format string, a ...interface{}) {
+	b.Privmsg(target, fmt.Sprintf(format, a...))
+}
+
+func (b *ircdBot) Join(channel string) {
+	b.server.channel(channel, true)
+}
+
+// watchIrcd starts the embedded server when [Ircd] is configured, letting
+// r2d2 run standalone with no outbound network connection.
+func watchIrcd() {
+	cfg := currentConfig()
+	if !cfg.Ircd.Enabled {
+		return
+	}
+	s := NewServer(cfg.Ircd.Listen, "r2d2.local")
+	if err := s.ListenAndServe(); err != nil {
+		log.Printf("ircd: listener on %s exited: %v", cfg.Ircd.Listen, err)
+	}
+}
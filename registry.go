@@ -0,0 +1,137 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// IRCClient is the subset of connection behaviour a Command needs. Both
+// *goirc.Connection (the normal network) and *TwitchConnection (the
+// Twitch dialect, see twitch.go) implement it, so commands work the same
+// regardless of which connection a request arrived on.
+type IRCClient interface {
+	Privmsg(target, message string)
+	Privmsgf(target, format string, a ...interface{})
+	Join(channel string)
+}
+
+// CommandContext carries everything a Command needs to know about the
+// caller and the connection it arrived on, so commands don't reach into
+// global IRC callback state themselves.
+type CommandContext struct {
+	Irc     IRCClient
+	Nick    string
+	User    string
+	Host    string
+	Channel string
+}
+
+// Hostmask returns the caller's nick!user@host, as matched against ACL
+// entries.
+func (ctx *CommandContext) Hostmask() string {
+	return ctx.Nick + "!" + ctx.User + "@" + ctx.Host
+}
+
+// Command is a self-contained bot command. Implementations register
+// themselves with RegisterCommand from an init() function so that adding
+// a command is a matter of adding a file, not editing a switch.
+type Command interface {
+	Name() string
+	Help() string
+	Run(ctx *CommandContext, args []string) (string, error)
+}
+
+// Registry holds the set of commands known to the bot, keyed by name.
+type Registry struct {
+	mu       sync.RWMutex
+	commands map[string]Command
+}
+
+// NewRegistry returns an empty command registry.
+func NewRegistry() *Registry {
+	return &Registry{commands: make(map[string]Command)}
+}
+
+// Register adds a command, panicking on a duplicate name since that can
+// only be a programming error at init time.
+func (r *Registry) Register(cmd Command) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.commands[cmd.Name()]; exists {
+		panic("command already registered: " + cmd.Name())
+	}
+	r.commands[cmd.Name()] = cmd
+}
+
+// Lookup returns the command registered under name, if any.
+func (r *Registry) Lookup(name string) (Command, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	cmd, ok := r.commands[name]
+	return cmd, ok
+}
+
+// Names returns the registered command names, sorted.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.commands))
+	for name := range r.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HelpSummary is the response to a bare "help" request: a generated list
+// of every registered command.
+func (r *Registry) HelpSummary() string {
+	return "try 'help <command>', supported commands are: " + strings.Join(r.Names(), ", ")
+}
+
+// HelpFor is the response to "help <name>".
+func (r *Registry) HelpFor(name string) string {
+	cmd, ok := r.Lookup(name)
+	if !ok {
+		return "there is no help for " + name
+	}
+	return cmd.Help()
+}
+
+// Dispatch parses req as "<command> <args...>", checks ACLs, and runs the
+// matching command. It never returns an error to the caller: Run errors
+// are logged and surfaced as a generic failure message.
+func (r *Registry) Dispatch(ctx *CommandContext, req string) string {
+	fields := strings.Fields(req)
+	if len(fields) == 0 {
+		return "I do not know how to answer this..."
+	}
+	name, args := fields[0], fields[1:]
+
+	cmd, ok := r.Lookup(name)
+	if !ok {
+		return "I do not know how to answer this..."
+	}
+	if !aclAllows(ctx, name, args) {
+		return "sorry, you are not allowed to do that"
+	}
+	resp, err := cmd.Run(ctx, args)
+	if err != nil {
+		log.Printf("command %q failed: %v", name, err)
+		return "something went wrong running " + name
+	}
+	return resp
+}
+
+// defaultRegistry is where commands register themselves at init time, and
+// what the PRIVMSG handlers (Supervisor.handlePrivmsg, watchTwitch and the
+// embedded server's handlePrivmsg) dispatch against.
+var defaultRegistry = NewRegistry()
+
+// RegisterCommand adds cmd to the default registry. Command files call
+// this from their own init().
+func RegisterCommand(cmd Command) {
+	defaultRegistry.Register(cmd)
+}
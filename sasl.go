@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	goirc "github.com/thoj/go-ircevent"
+)
+
+// configureSaslTLS loads the client certificate for SASL EXTERNAL, if one
+// is configured, and attaches it to irc's TLS config before Connect.
+func configureSaslTLS(irc *goirc.Connection) error {
+	cfg := currentConfig()
+	if cfg.Irc.SaslCertFile == "" {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.Irc.SaslCertFile, cfg.Irc.SaslKeyFile)
+	if err != nil {
+		return err
+	}
+	irc.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	return nil
+}
+
+// saslTimeout bounds each step of the handshake so a network that never
+// responds doesn't hang registration forever.
+const saslTimeout = 10 * time.Second
+
+// saslWantedCaps are requested in addition to sasl itself, when the
+// server offers them.
+var saslWantedCaps = []string{"server-time", "message-tags", "account-notify", "extended-join"}
+
+// authenticate performs the IRCv3 SASL handshake (CAP LS 302, AUTHENTICATE
+// PLAIN or EXTERNAL, CAP END) and falls back to the legacy NickServ NOTICE
+// dance if the server doesn't advertise the sasl capability. Either way,
+// it blocks until authentication is complete, so callers can JOIN right
+// after it returns, matching prior behavior.
+func authenticate(irc *goirc.Connection) {
+	caps, ok := requestCapList(irc)
+	if !ok || !stringInSlice("sasl", caps) {
+		irc.SendRawf("CAP END")
+		legacyNickservAuth(irc)
+		return
+	}
+
+	if !negotiateCaps(irc, caps) {
+		irc.SendRawf("CAP END")
+		legacyNickservAuth(irc)
+		return
+	}
+
+	if !saslAuthenticate(irc) {
+		log.Printf("sasl: authentication failed, falling back to NickServ")
+		irc.SendRawf("CAP END")
+		legacyNickservAuth(irc)
+		return
+	}
+
+	irc.SendRawf("CAP END")
+}
+
+// requestCapList sends CAP LS 302 and waits for the server's capability
+// list. A 302 response may be split across several "CAP * LS * :..."
+// lines, each but the last marked with a trailing "*" parameter; chunks
+// are accumulated until a line without that marker ends the list. Values
+// attached to a capability (as in "sasl=PLAIN,EXTERNAL") are stripped, so
+// the returned list holds bare capability names.
+func requestCapList(irc *goirc.Connection) ([]string, bool) {
+	lines := make(chan *goirc.Event, 8)
+	irc.AddCallback("CAP", func(e *goirc.Event) {
+		if len(e.Arguments) >= 2 && e.Arguments[1] == "LS" {
+			select {
+			case lines <- e:
+			default:
+			}
+		}
+	})
+	defer irc.ClearCallback("CAP")
+
+	irc.SendRawf("CAP LS 302")
+	var caps []string
+	for {
+		select {
+		case e := <-lines:
+			for _, c := range strings.Fields(e.Message()) {
+				caps = append(caps, strings.SplitN(c, "=", 2)[0])
+			}
+			continuation := len(e.Arguments) >= 3 && e.Arguments[2] == "*"
+			if !continuation {
+				return caps, true
+			}
+		case <-time.After(saslTimeout):
+			log.Printf("sasl: no response to CAP LS, server likely doesn't support IRCv3")
+			return nil, false
+		}
+	}
+}
+
+// negotiateCaps requests sasl plus whichever of saslWantedCaps the server
+// advertised, and waits for the server to ACK them.
+func negotiateCaps(irc *goirc.Connection, available []string) bool {
+	req := []string{"sasl"}
+	for _, want := range saslWantedCaps {
+		if stringInSlice(want, available) {
+			req = append(req, want)
+		}
+	}
+
+	ack := make(chan bool, 1)
+	irc.AddCallback("CAP", func(e *goirc.Event) {
+		if len(e.Arguments) >= 2 && (e.Arguments[1] == "ACK" || e.Arguments[1] == "NAK") {
+			select {
+			case ack <- e.Arguments[1] == "ACK":
+			default:
+			}
+		}
+	})
+	defer irc.ClearCallback("CAP")
+
+	irc.SendRawf("CAP REQ :%s", strings.Join(req, " "))
+	select {
+	case ok := <-ack:
+		return ok
+	case <-time.After(saslTimeout):
+		log.Printf("sasl: no response to CAP REQ")
+		return false
+	}
+}
+
+// saslAuthenticate runs the AUTHENTICATE exchange itself, using EXTERNAL
+// (client certificate) when one is configured and PLAIN otherwise.
+func saslAuthenticate(irc *goirc.Connection) bool {
+	cfg := currentConfig()
+	mechanism := "PLAIN"
+	if cfg.Irc.SaslCertFile != "" {
+		mechanism = "EXTERNAL"
+	}
+
+	cont := make(chan bool, 1)
+	irc.AddCallback("AUTHENTICATE", func(e *goirc.Event) {
+		if e.Message() == "+" {
+			select {
+			case cont <- true:
+			default:
+			}
+		}
+	})
+	defer irc.ClearCallback("AUTHENTICATE")
+
+	result := make(chan error, 1)
+	push := func(err error) {
+		select {
+		case result <- err:
+		default:
+		}
+	}
+	irc.AddCallback("903", func(e *goirc.Event) { push(nil) })
+	irc.AddCallback("904", func(e *goirc.Event) { push(fmt.Errorf("SASL authentication failed")) })
+	irc.AddCallback("905", func(e *goirc.Event) { push(fmt.Errorf("SASL message too long")) })
+	defer irc.ClearCallback("903")
+	defer irc.ClearCallback("904")
+	defer irc.ClearCallback("905")
+
+	irc.SendRawf("AUTHENTICATE %s", mechanism)
+	select {
+	case <-cont:
+	case <-time.After(saslTimeout):
+		log.Printf("sasl: server did not prompt for %s credentials", mechanism)
+		return false
+	}
+
+	var payload string
+	if mechanism == "PLAIN" {
+		payload = "\x00" + cfg.Irc.SaslUser + "\x00" + cfg.Irc.SaslPass
+	}
+	sendSaslPayload(irc, payload)
+
+	select {
+	case err := <-result:
+		if err != nil {
+			log.Printf("sasl: %v", err)
+			return false
+		}
+		return true
+	case <-time.After(saslTimeout):
+		log.Printf("sasl: timed out waiting for 903/904/905")
+		return false
+	}
+}
+
+// saslChunkSize is the maximum length of a single AUTHENTICATE parameter,
+// per the SASL IRCv3 spec.
+const saslChunkSize = 400
+
+// saslEncodeChunks base64-encodes payload and splits it into saslChunkSize
+// pieces for AUTHENTICATE, plus a final empty chunk when the encoded length
+// is an exact multiple of saslChunkSize (including zero, for EXTERNAL):
+// that empty chunk is what tells sendSaslPayload to send the terminating
+// bare "AUTHENTICATE +".
+func saslEncodeChunks(payload string) []string {
+	encoded := base64.StdEncoding.EncodeToString([]byte(payload))
+	var chunks []string
+	for i := 0; i < len(encoded); i += saslChunkSize {
+		end := i + saslChunkSize
+		if end > len(encoded) {
+			end = len(encoded)
+		}
+		chunks = append(chunks, encoded[i:end])
+	}
+	if len(encoded)%saslChunkSize == 0 {
+		chunks = append(chunks, "")
+	}
+	return chunks
+}
+
+// sendSaslPayload sends payload as one or more AUTHENTICATE lines, base64
+// encoding and chunking it via saslEncodeChunks.
+func sendSaslPayload(irc *goirc.Connection, payload string) {
+	for _, chunk := range saslEncodeChunks(payload) {
+		if chunk == "" {
+			irc.SendRawf("AUTHENTICATE +")
+			continue
+		}
+		irc.SendRawf("AUTHENTICATE %s", chunk)
+	}
+}
+
+// legacyNickservAuth is the original auth path: NOTICE-scraping for
+// NickServ's IDENTIFY prompt. Used when the server doesn't advertise SASL
+// support, and also when the sasl capability request or the AUTHENTICATE
+// exchange fails.
+func legacyNickservAuth(irc *goirc.Connection) {
+	cfg := currentConfig()
+	if cfg.Irc.Nickpass == "" {
+		return
+	}
+	identwaiter := make(chan bool)
+	irc.AddCallback("NOTICE", func(e *goirc.Event) {
+		re := regexp.MustCompile("NickServ IDENTIFY")
+		if e.Nick == "NickServ" && re.MatchString(e.Message()) {
+			irc.Privmsgf("NickServ", "IDENTIFY %s", cfg.Irc.Nickpass)
+		}
+		reaccepted := regexp.MustCompile("(?i)Password accepted")
+		if e.Nick == "NickServ" && reaccepted.MatchString(e.Message()) {
+			identwaiter <- true
+		}
+	})
+	for {
+		select {
+		case <-identwaiter:
+			goto identified
+		case <-time.After(5 * time.Second):
+			irc.Privmsgf("NickServ", "IDENTIFY %s", cfg.Irc.Nickpass)
+		}
+	}
+identified:
+	irc.ClearCallback("NOTICE")
+	close(identwaiter)
+}
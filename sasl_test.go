@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestSaslEncodeChunks(t *testing.T) {
+	cases := []struct {
+		name    string
+		payload string
+	}{
+		{"external (empty)", ""},
+		{"short", "\x00user\x00pass"},
+		{"exact multiple of chunk size", strings.Repeat("x", 300)}, // encodes to exactly 400 bytes
+		{"just over a chunk boundary", strings.Repeat("x", 301)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			chunks := saslEncodeChunks(c.payload)
+			if len(chunks) == 0 {
+				t.Fatal("saslEncodeChunks returned no chunks")
+			}
+
+			var encoded strings.Builder
+			for _, chunk := range chunks[:len(chunks)-1] {
+				if len(chunk) > saslChunkSize {
+					t.Errorf("chunk %q exceeds saslChunkSize", chunk)
+				}
+				encoded.WriteString(chunk)
+			}
+			last := chunks[len(chunks)-1]
+			terminates := last == ""
+			if !terminates {
+				encoded.WriteString(last)
+			}
+
+			want := base64.StdEncoding.EncodeToString([]byte(c.payload))
+			if got := encoded.String(); got != want {
+				t.Errorf("reassembled chunks = %q, want %q", got, want)
+			}
+			if len(want)%saslChunkSize == 0 && !terminates {
+				t.Errorf("payload encodes to a multiple of saslChunkSize, want a terminating empty chunk")
+			}
+		})
+	}
+}
+
+func TestSaslEncodeChunksRoundTrip(t *testing.T) {
+	chunks := saslEncodeChunks("\x00alice\x00hunter2")
+	if len(chunks) == 0 {
+		t.Fatal("expected at least one chunk")
+	}
+	var encoded strings.Builder
+	for _, chunk := range chunks {
+		encoded.WriteString(chunk)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded.String())
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if string(decoded) != "\x00alice\x00hunter2" {
+		t.Errorf("decoded = %q, want %q", decoded, "\x00alice\x00hunter2")
+	}
+}
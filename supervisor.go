@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	goirc "github.com/thoj/go-ircevent"
+)
+
+const (
+	backoffInitial   = 1 * time.Second
+	backoffMax       = 5 * time.Minute
+	outboundQueueCap = 100
+)
+
+// outboundQueue is a bounded, drop-oldest buffer of messages destined for
+// one channel while the bot is disconnected.
+type outboundQueue struct {
+	mu      sync.Mutex
+	pending []string
+	dropped int
+}
+
+func (q *outboundQueue) enqueue(message string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) >= outboundQueueCap {
+		q.pending = q.pending[1:]
+		q.dropped++
+	}
+	q.pending = append(q.pending, message)
+}
+
+// drain returns the buffered messages and how many were dropped to make
+// room, resetting the queue.
+func (q *outboundQueue) drain() ([]string, int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	pending, dropped := q.pending, q.dropped
+	q.pending, q.dropped = nil, 0
+	return pending, dropped
+}
+
+// Supervisor owns the IRC connection's lifecycle: connect, authenticate,
+// join, run the configured watchers, and on disconnect reconnect with
+// exponential backoff, replaying anything queued while offline.
+type Supervisor struct {
+	mu         sync.RWMutex
+	irc        *goirc.Connection
+	connected  bool
+	lastError  string
+	reconnects int
+	startedAt  time.Time
+
+	channelsMu sync.Mutex
+	channels   map[string]bool
+
+	queuesMu sync.Mutex
+	queues   map[string]*outboundQueue
+}
+
+// NewSupervisor returns a supervisor that hasn't connected yet.
+func NewSupervisor() *Supervisor {
+	cfg := currentConfig()
+	return &Supervisor{
+		startedAt: time.Now(),
+		channels:  map[string]bool{cfg.Irc.Channel: true},
+		queues:    map[string]*outboundQueue{},
+	}
+}
+
+// Run connects and authenticates, then blocks forever, reconnecting with
+// backoff whenever the connection drops. Watchers are started once, from
+// main, against Client() rather than here: Client() always resolves to
+// whatever connection is currently live, so nothing needs restarting
+// per-connection and nothing leaks goroutines across reconnects.
+func (s *Supervisor) Run() {
+	backoff := backoffInitial
+	for {
+		irc, err := s.connectOnce()
+		if err != nil {
+			s.recordError(err)
+			delay := jitter(backoff)
+			log.Printf("supervisor: connect failed (%v), retrying in %s", err, delay)
+			time.Sleep(delay)
+			backoff = nextBackoff(backoff)
+			continue
+		}
+
+		backoff = backoffInitial
+		s.mu.Lock()
+		s.irc = irc
+		s.connected = true
+		s.lastError = ""
+		s.mu.Unlock()
+
+		disconnected := make(chan bool, 1)
+		notify := func(e *goirc.Event) {
+			select {
+			case disconnected <- true:
+			default:
+			}
+		}
+		irc.AddCallback("DISCONNECTED", notify)
+
+		s.rejoinAll(irc)
+		s.flushQueues(irc)
+
+		<-disconnected
+		s.mu.Lock()
+		s.connected = false
+		s.reconnects++
+		s.mu.Unlock()
+		log.Printf("supervisor: disconnected, reconnecting (attempt %d)", s.reconnects+1)
+	}
+}
+
+// connectOnce performs a single connection attempt: dial, SASL, and the
+// PRIVMSG dispatch callback. It does not join channels so that Run can
+// do so uniformly for the first connect and every reconnect.
+func (s *Supervisor) connectOnce() (*goirc.Connection, error) {
+	cfg := currentConfig()
+	irc := goirc.IRC(cfg.Irc.Nick, cfg.Irc.Nick)
+	irc.UseTLS = cfg.Irc.TLS
+	irc.VerboseCallbackHandler = cfg.Irc.Debug
+	irc.Debug = cfg.Irc.Debug
+	irc.Timeout = 300 * time.Second
+	irc.PingFreq = 10 * time.Second
+	irc.KeepAlive = 10 * time.Second
+	if err := configureSaslTLS(irc); err != nil {
+		return nil, err
+	}
+	if err := irc.Connect(cfg.Irc.Server); err != nil {
+		return nil, err
+	}
+
+	authenticate(irc)
+
+	irc.AddCallback("PRIVMSG", func(e *goirc.Event) {
+		s.handlePrivmsg(irc, e)
+	})
+
+	return irc, nil
+}
+
+func (s *Supervisor) handlePrivmsg(irc *goirc.Connection, e *goirc.Event) {
+	cfg := currentConfig()
+	if cfg.Irc.Debug {
+		log.Printf("%+v", e)
+	}
+	re := regexp.MustCompile("^" + cfg.Irc.Nick + ":(.+)$")
+	if !re.MatchString(e.Message()) {
+		return
+	}
+	parsed := re.FindStringSubmatch(e.Message())
+	if len(parsed) != 2 {
+		log.Printf("Could not find a message body to work with. event=%+v", e)
+		return
+	}
+	irchan := cfg.Irc.Channel
+	if len(e.Arguments) > 0 {
+		irchan = e.Arguments[0]
+	}
+	req := strings.Trim(parsed[1], " ")
+	ctx := &CommandContext{Irc: s.Client(), Nick: e.Nick, User: e.User, Host: e.Host, Channel: irchan}
+	resp := defaultRegistry.Dispatch(ctx, req)
+	log.Printf("responding with %q", resp)
+	for i := 0; i < len(resp); i += 300 {
+		upper := i + 300
+		if upper > len(resp) {
+			upper = len(resp)
+		}
+		s.send(irchan, fmt.Sprintf("%s: %s", e.Nick, resp[i:upper]))
+	}
+}
+
+// rejoinAll joins every channel the bot is supposed to be in: the
+// configured home channel plus any joined at runtime (e.g. via the "join"
+// command) before a disconnect.
+func (s *Supervisor) rejoinAll(irc *goirc.Connection) {
+	cfg := currentConfig()
+	if cfg.Irc.ChannelPass != "" {
+		irc.Join(cfg.Irc.Channel + " " + cfg.Irc.ChannelPass)
+	} else {
+		irc.Join(cfg.Irc.Channel)
+	}
+	if cfg.Irc.Debug {
+		irc.Privmsg(cfg.Irc.Channel, "beep beedibeep dibeep")
+	}
+
+	s.channelsMu.Lock()
+	defer s.channelsMu.Unlock()
+	for channel := range s.channels {
+		if channel == cfg.Irc.Channel {
+			continue
+		}
+		irc.Join(channel)
+	}
+}
+
+// flushQueues sends everything buffered while disconnected, posting a
+// "N messages dropped" summary first to any channel that overflowed.
+func (s *Supervisor) flushQueues(irc *goirc.Connection) {
+	s.queuesMu.Lock()
+	queues := make(map[string]*outboundQueue, len(s.queues))
+	for channel, q := range s.queues {
+		queues[channel] = q
+	}
+	s.queuesMu.Unlock()
+
+	for channel, q := range queues {
+		pending, dropped := q.drain()
+		if dropped > 0 {
+			irc.Privmsgf(channel, "(%d messages dropped while offline)", dropped)
+		}
+		for _, message := range pending {
+			irc.Privmsg(channel, message)
+		}
+	}
+}
+
+// send delivers a message immediately if connected, or queues it (bounded,
+// drop-oldest) for delivery on the next reconnect.
+func (s *Supervisor) send(channel, message string) {
+	s.mu.RLock()
+	irc, connected := s.irc, s.connected
+	s.mu.RUnlock()
+	if connected && irc != nil {
+		irc.Privmsg(channel, message)
+		return
+	}
+	s.queueFor(channel).enqueue(message)
+}
+
+func (s *Supervisor) queueFor(channel string) *outboundQueue {
+	s.queuesMu.Lock()
+	defer s.queuesMu.Unlock()
+	q, ok := s.queues[channel]
+	if !ok {
+		q = &outboundQueue{}
+		s.queues[channel] = q
+	}
+	return q
+}
+
+// join marks channel as one the bot should be in, joining immediately if
+// connected and rejoining automatically after future reconnects.
+func (s *Supervisor) join(channel string) {
+	s.channelsMu.Lock()
+	s.channels[channel] = true
+	s.channelsMu.Unlock()
+
+	s.mu.RLock()
+	irc, connected := s.irc, s.connected
+	s.mu.RUnlock()
+	if connected && irc != nil {
+		irc.Join(channel)
+	}
+}
+
+func (s *Supervisor) recordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastError = err.Error()
+}
+
+// Client returns an IRCClient that routes through this supervisor's queue
+// instead of a connection that may go away underneath it.
+func (s *Supervisor) Client() IRCClient {
+	return &supervisorClient{s: s}
+}
+
+type supervisorClient struct {
+	s *Supervisor
+}
+
+func (c *supervisorClient) Privmsg(target, message string) { c.s.send(target, message) }
+func (c *supervisorClient) Privmsgf(target, format string, a ...interface{}) {
+	c.s.send(target, fmt.Sprintf(format, a...))
+}
+func (c *supervisorClient) Join(channel string) { c.s.join(channel) }
+
+// status is the JSON shape served at /healthz.
+type status struct {
+	Connected      bool   `json:"connected"`
+	LastError      string `json:"lastError,omitempty"`
+	ReconnectCount int    `json:"reconnectCount"`
+	UptimeSeconds  int    `json:"uptimeSeconds"`
+}
+
+func (s *Supervisor) status() status {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return status{
+		Connected:      s.connected,
+		LastError:      s.lastError,
+		ReconnectCount: s.reconnects,
+		UptimeSeconds:  int(time.Since(s.startedAt).Seconds()),
+	}
+}
+
+// ServeHTTP starts the /healthz and /metrics endpoints if [Supervisor]
+// Listen is configured. It runs until the listener fails.
+func (s *Supervisor) ServeHTTP() {
+	cfg := currentConfig()
+	if cfg.Supervisor.Listen == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.status())
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		st := s.status()
+		connected := 0
+		if st.Connected {
+			connected = 1
+		}
+		fmt.Fprintf(w, "r2d2_connected %d\n", connected)
+		fmt.Fprintf(w, "r2d2_reconnect_count %d\n", st.ReconnectCount)
+		fmt.Fprintf(w, "r2d2_uptime_seconds %d\n", st.UptimeSeconds)
+	})
+	if err := http.ListenAndServe(cfg.Supervisor.Listen, mux); err != nil {
+		log.Printf("supervisor: healthz listener on %s exited: %v", cfg.Supervisor.Listen, err)
+	}
+}
+
+// jitter adds up to 20% random variance to a backoff so many disconnected
+// bots don't all hammer the server on the same schedule.
+func jitter(d time.Duration) time.Duration {
+	return d + time.Duration(rand.Int63n(int64(d)/5+1))
+}
+
+func nextBackoff(d time.Duration) time.Duration {
+	next := d * 2
+	if next > backoffMax {
+		next = backoffMax
+	}
+	return next
+}
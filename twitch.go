@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// twitchCaps are the IRCv3 capabilities r2d2 needs from Twitch: tags for
+// badge/sub/bits metadata, commands for CLEARCHAT/USERNOTICE/etc, and
+// membership for JOIN/PART/NAMES on large channels.
+var twitchCaps = []string{
+	"twitch.tv/tags",
+	"twitch.tv/commands",
+	"twitch.tv/membership",
+}
+
+// TwitchEvent is a single line received from Twitch IRC, with its IRCv3
+// tags parsed into a map so command handlers can read subscriber/mod/bits
+// metadata without re-parsing the raw line.
+type TwitchEvent struct {
+	Tags    map[string]string
+	Nick    string
+	Command string
+	Params  []string
+}
+
+// Message is the trailing parameter, mirroring goirc.Event.Message().
+func (e *TwitchEvent) Message() string {
+	if len(e.Params) == 0 {
+		return ""
+	}
+	return e.Params[len(e.Params)-1]
+}
+
+// TwitchConnection speaks Twitch's IRC dialect directly: PASS oauth:<token>
+// authentication and the tags/commands/membership capabilities, neither of
+// which the vendored goirc client understands.
+type TwitchConnection struct {
+	Nick       string
+	OAuthToken string
+	Debug      bool
+
+	mu        sync.Mutex
+	conn      net.Conn
+	writer    *bufio.Writer
+	callbacks map[string][]func(*TwitchEvent)
+}
+
+// NewTwitchConnection returns a connection ready to Connect.
+func NewTwitchConnection(nick, oauthToken string) *TwitchConnection {
+	return &TwitchConnection{
+		Nick:       nick,
+		OAuthToken: oauthToken,
+		callbacks:  make(map[string][]func(*TwitchEvent)),
+	}
+}
+
+// Connect dials server (expected to be irc.chat.twitch.tv:6697), performs
+// capability negotiation, and authenticates. It returns once registration
+// has been sent; callers should then call Loop.
+func (t *TwitchConnection) Connect(server string) error {
+	conn, err := tls.Dial("tcp", server, &tls.Config{})
+	if err != nil {
+		return err
+	}
+	t.conn = conn
+	t.writer = bufio.NewWriter(conn)
+
+	t.send("CAP REQ :" + strings.Join(twitchCaps, " "))
+	t.send("PASS oauth:" + strings.TrimPrefix(t.OAuthToken, "oauth:"))
+	t.send("NICK " + t.Nick)
+	t.send("CAP END")
+	return nil
+}
+
+func (t *TwitchConnection) send(line string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Debug {
+		log.Printf("twitch -> %s", line)
+	}
+	fmt.Fprintf(t.writer, "%s\r\n", line)
+	t.writer.Flush()
+}
+
+// Join joins a Twitch channel.
+func (t *TwitchConnection) Join(channel string) {
+	t.send("JOIN " + channel)
+}
+
+// Privmsg sends a regular chat message.
+func (t *TwitchConnection) Privmsg(target, message string) {
+	t.send(fmt.Sprintf("PRIVMSG %s :%s", target, message))
+}
+
+// Privmsgf is Privmsg with fmt.Sprintf-style formatting.
+func (t *TwitchConnection) Privmsgf(target, format string, a ...interface{}) {
+	t.Privmsg(target, fmt.Sprintf(format, a...))
+}
+
+// Me sends a /me action, Twitch's chat convention for third-person text.
+func (t *TwitchConnection) Me(target, action string) {
+	t.Privmsg(target, "\x01ACTION "+action+"\x01")
+}
+
+// AddCallback registers fn to run for every event with the given command
+// (e.g. "PRIVMSG", "USERNOTICE"), mirroring goirc's AddCallback so the two
+// connection types feel the same to maintain.
+func (t *TwitchConnection) AddCallback(command string, fn func(*TwitchEvent)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.callbacks[command] = append(t.callbacks[command], fn)
+}
+
+// Loop reads and dispatches events until the connection closes.
+func (t *TwitchConnection) Loop() {
+	scanner := bufio.NewScanner(t.conn)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r\n")
+		if t.Debug {
+			log.Printf("twitch <- %s", line)
+		}
+		event := parseTwitchLine(line)
+		if event == nil {
+			continue
+		}
+		if event.Command == "PING" {
+			t.send("PONG :" + event.Message())
+			continue
+		}
+		t.dispatch(event)
+	}
+}
+
+func (t *TwitchConnection) dispatch(event *TwitchEvent) {
+	t.mu.Lock()
+	fns := append([]func(*TwitchEvent){}, t.callbacks[event.Command]...)
+	t.mu.Unlock()
+	for _, fn := range fns {
+		fn(event)
+	}
+}
+
+// unescapeTagValue reverses the IRCv3 message-tags escaping applied to a
+// single tag value: "\:" -> ";", "\s" -> " ", "\\" -> "\", "\r" -> CR,
+// "\n" -> LF. A trailing backslash with nothing to escape is dropped, per
+// the spec.
+func unescapeTagValue(value string) string {
+	if !strings.ContainsRune(value, '\\') {
+		return value
+	}
+	var b strings.Builder
+	b.Grow(len(value))
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			b.WriteByte(value[i])
+			continue
+		}
+		if i == len(value)-1 {
+			break
+		}
+		i++
+		switch value[i] {
+		case ':':
+			b.WriteByte(';')
+		case 's':
+			b.WriteByte(' ')
+		case '\\':
+			b.WriteByte('\\')
+		case 'r':
+			b.WriteByte('\r')
+		case 'n':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(value[i])
+		}
+	}
+	return b.String()
+}
+
+// parseTwitchLine parses a raw IRC line, including the optional leading
+// "@key=value;key=value " IRCv3 tags block, e.g.:
+//
+//	@badges=subscriber/6;color=#FF0000;subscriber=1 :user!user@user.tmi.twitch.tv PRIVMSG #chan :hi
+func parseTwitchLine(line string) *TwitchEvent {
+	if line == "" {
+		return nil
+	}
+	event := &TwitchEvent{Tags: map[string]string{}}
+
+	if strings.HasPrefix(line, "@") {
+		sp := strings.IndexByte(line, ' ')
+		if sp < 0 {
+			return nil
+		}
+		for _, pair := range strings.Split(line[1:sp], ";") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) == 2 {
+				event.Tags[kv[0]] = unescapeTagValue(kv[1])
+			} else {
+				event.Tags[kv[0]] = ""
+			}
+		}
+		line = line[sp+1:]
+	}
+
+	if strings.HasPrefix(line, ":") {
+		sp := strings.IndexByte(line, ' ')
+		if sp < 0 {
+			return nil
+		}
+		prefix := line[1:sp]
+		event.Nick = strings.SplitN(prefix, "!", 2)[0]
+		line = line[sp+1:]
+	}
+
+	var trailing string
+	hasTrailing := false
+	if idx := strings.Index(line, " :"); idx >= 0 {
+		trailing = line[idx+2:]
+		hasTrailing = true
+		line = line[:idx]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	event.Command = fields[0]
+	event.Params = fields[1:]
+	if hasTrailing {
+		event.Params = append(event.Params, trailing)
+	}
+	return event
+}
+
+// watchTwitch connects to Twitch chat and wires incoming PRIVMSGs into the
+// shared command registry, when [Twitch] is configured.
+func watchTwitch() {
+	cfg := currentConfig()
+	if !cfg.Twitch.Enabled {
+		return
+	}
+	t := NewTwitchConnection(cfg.Twitch.Nick, cfg.Twitch.OAuthToken)
+	t.Debug = cfg.Twitch.Debug
+	if err := t.Connect("irc.chat.twitch.tv:6697"); err != nil {
+		log.Printf("twitch: connect failed: %v", err)
+		return
+	}
+	for _, channel := range cfg.Twitch.Channels {
+		t.Join(channel)
+	}
+
+	t.AddCallback("PRIVMSG", func(e *TwitchEvent) {
+		if !strings.HasPrefix(e.Message(), cfg.Twitch.Nick+":") {
+			return
+		}
+		req := strings.TrimSpace(strings.TrimPrefix(e.Message(), cfg.Twitch.Nick+":"))
+		channel := ""
+		if len(e.Params) > 0 {
+			channel = e.Params[0]
+		}
+		ctx := &CommandContext{Irc: t, Nick: e.Nick, User: e.Nick, Host: "tmi.twitch.tv", Channel: channel}
+		resp := defaultRegistry.Dispatch(ctx, req)
+		t.Privmsgf(channel, "%s: %s", e.Nick, resp)
+	})
+
+	t.AddCallback("USERNOTICE", func(e *TwitchEvent) {
+		if cfg.Twitch.Debug {
+			log.Printf("twitch usernotice: msg-id=%s system-msg=%s", e.Tags["msg-id"], e.Tags["system-msg"])
+		}
+	})
+
+	go func() {
+		t.Loop()
+		log.Printf("twitch: connection loop exited")
+	}()
+
+	pinger := time.NewTicker(2 * time.Minute)
+	defer pinger.Stop()
+	for range pinger.C {
+		t.send("PING :tmi.twitch.tv")
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnescapeTagValue(t *testing.T) {
+	cases := []struct{ in, want string }{
+		{"", ""},
+		{"plain", "plain"},
+		{`sub\sonly`, "sub only"},
+		{`a\:b`, "a;b"},
+		{`a\\b`, `a\b`},
+		{`line\r\nbreak`, "line\r\nbreak"},
+		{`trailing\`, "trailing"},
+	}
+	for _, c := range cases {
+		if got := unescapeTagValue(c.in); got != c.want {
+			t.Errorf("unescapeTagValue(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestParseTwitchLineTags(t *testing.T) {
+	line := `@badges=subscriber/6;system-msg=chat\sis\snow\sin\sfollowers-only\smode :tmi.twitch.tv USERNOTICE #chan :hi`
+	event := parseTwitchLine(line)
+	if event == nil {
+		t.Fatal("parseTwitchLine returned nil")
+	}
+	want := map[string]string{
+		"badges":     "subscriber/6",
+		"system-msg": "chat is now in followers-only mode",
+	}
+	if !reflect.DeepEqual(event.Tags, want) {
+		t.Errorf("Tags = %#v, want %#v", event.Tags, want)
+	}
+	if event.Command != "USERNOTICE" {
+		t.Errorf("Command = %q, want USERNOTICE", event.Command)
+	}
+}